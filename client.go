@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client 代表一个已升级的 WebSocket 连接，归属于某个房间。
+type Client struct {
+	hub        *Hub
+	conn       *websocket.Conn
+	send       chan wsFrame
+	room       string
+	id         string // 用于日志标识（remote addr）
+	connID     string // 用于 -record / /trace/{id}
+	compressed bool   // 本连接是否协商了 permessage-deflate
+}
+
+func (c *Client) roomName() string       { return c.room }
+func (c *Client) sendChan() chan wsFrame { return c.send }
+
+// evicted 无需额外处理：writePump 在 c.send 被关闭后会自行退出并关闭连接。
+func (c *Client) evicted() {}
+
+// readPump 持续读取该连接的帧，并转发给 hub 在房间内广播。
+// 必须在连接所在的 goroutine 中调用；退出时负责注销与关闭连接。
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(*readLimit)
+	c.conn.SetReadDeadline(time.Now().Add(*readTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(*readTimeout))
+		return nil
+	})
+
+	for {
+		mt, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("client %s closed: %v", c.id, err)
+			} else {
+				log.Printf("read error (%s): %v", c.id, err)
+			}
+			return
+		}
+
+		preview := string(msg)
+		if len(preview) > 512 {
+			preview = preview[:512] + "...(truncated)"
+		}
+		log.Printf("recv message: room=%s from=%s type=%s len=%d preview=%q",
+			c.room, c.id, messageTypeName(mt), len(msg), preview)
+		recorder.record(newTraceEvent(c.connID, c.id, "recv", messageTypeName(mt), msg))
+
+		c.hub.broadcast <- roomMessage{room: c.room, mt: mt, data: msg, sender: c}
+	}
+}
+
+// writePump 把广播给该客户端的帧写出去，并定时发送 ping 保活。
+// hub 关闭 c.send 后，本 goroutine 负责发送关闭帧并退出。
+func (c *Client) writePump() {
+	ticker := time.NewTicker(*pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case frame, ok := <-c.send:
+			if !ok {
+				_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(*writeTimeout))
+				return
+			}
+			if c.compressed {
+				c.conn.EnableWriteCompression(len(frame.data) >= *compressThreshold)
+			}
+			if err := c.conn.WriteMessage(frame.mt, frame.data); err != nil {
+				log.Printf("write error (%s): %v", c.id, err)
+				return
+			}
+			recorder.record(newTraceEvent(c.connID, c.id, "send", messageTypeName(frame.mt), frame.data))
+
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(*writeTimeout)); err != nil {
+				log.Printf("write ping error (%s): %v", c.id, err)
+				return
+			}
+		}
+	}
+}
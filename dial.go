@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// headerFlags 收集重复出现的 `-header key=value` 标志。
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *headerFlags) Set(v string) error {
+	*h = append(*h, v)
+	return nil
+}
+
+func (h headerFlags) toHTTPHeader() (http.Header, error) {
+	out := http.Header{}
+	for _, kv := range h {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid -header %q, expected key=value", kv)
+		}
+		out.Add(parts[0], parts[1])
+	}
+	return out, nil
+}
+
+// buildDialerAndHeader 根据 -header/-origin/-subprotocol/-insecure/-proxy
+// 构造一次出站连接共用的 dialer 与请求头，供 -dial 与 -replay 两条路径复用，
+// 以免 replay 悄悄丢掉这些客户端定制项。
+func buildDialerAndHeader() (websocket.Dialer, http.Header, error) {
+	header, err := dialHeaders.toHTTPHeader()
+	if err != nil {
+		return websocket.Dialer{}, nil, err
+	}
+	if *dialOrigin != "" {
+		header.Set("Origin", *dialOrigin)
+	}
+
+	dialer := *websocket.DefaultDialer
+	if *dialSubprotocol != "" {
+		dialer.Subprotocols = strings.Split(*dialSubprotocol, ",")
+	}
+	if *dialInsecure {
+		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if *dialProxy != "" {
+		proxyURL, err := url.Parse(*dialProxy)
+		if err != nil {
+			return websocket.Dialer{}, nil, fmt.Errorf("invalid -proxy: %w", err)
+		}
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return dialer, header, nil
+}
+
+// runDialClient 把本进程从服务端切换为客户端：连接 -dial 指定的地址，
+// 中继 stdin 作为出站帧，并把收到的每一帧打印到 stdout/日志。
+func runDialClient() error {
+	dialer, header, err := buildDialerAndHeader()
+	if err != nil {
+		return err
+	}
+
+	conn, resp, err := dialer.Dial(*dialURL, header)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", *dialURL, err)
+	}
+	defer conn.Close()
+
+	log.Printf("connected to %s", *dialURL)
+	log.Printf("negotiated subprotocol: %q", conn.Subprotocol())
+	log.Printf("negotiated extensions: %q", resp.Header.Get("Sec-WebSocket-Extensions"))
+	log.Printf("response headers: %v", resp.Header)
+	if resp.TLS != nil {
+		log.Printf("TLS: version=%x cipher=%x negotiatedProtocol=%q", resp.TLS.Version, resp.TLS.CipherSuite, resp.TLS.NegotiatedProtocol)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				log.Printf("read error: %v", err)
+				return
+			}
+			preview := string(msg)
+			if len(preview) > 512 {
+				preview = preview[:512] + "...(truncated)"
+			}
+			log.Printf("recv message: type=%s len=%d preview=%q", messageTypeName(mt), len(msg), preview)
+		}
+	}()
+
+	if *dialBinary {
+		relayBinaryStdin(conn)
+	} else {
+		relayTextStdin(conn)
+	}
+
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(*writeTimeout))
+	<-done
+	return nil
+}
+
+// relayTextStdin 把 stdin 的每一行作为一个文本帧发送。
+func relayTextStdin(conn *websocket.Conn) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := conn.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
+			log.Printf("write error: %v", err)
+			return
+		}
+	}
+}
+
+// relayBinaryStdin 把 stdin 解析为一系列 4 字节大端长度前缀 + 载荷的分块，
+// 每个分块作为一个二进制帧发送。
+func relayBinaryStdin(conn *websocket.Conn) {
+	reader := bufio.NewReader(os.Stdin)
+	var lenBuf [4]byte
+	for {
+		if _, err := io.ReadFull(reader, lenBuf[:]); err != nil {
+			if err != io.EOF {
+				log.Printf("stdin read error: %v", err)
+			}
+			return
+		}
+		n := binary.BigEndian.Uint32(lenBuf[:])
+		chunk := make([]byte, n)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			log.Printf("stdin read error: %v", err)
+			return
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+			log.Printf("write error: %v", err)
+			return
+		}
+	}
+}
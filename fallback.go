@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Session 是长轮询 / EventSource 回退传输下的"一个连接"，与 Client 共享
+// 同一个 Hub：它同样实现 member 接口，因此房间广播、/stats 统计对两种
+// 传输是一致的。sid 由服务端生成，客户端把它编码进 URL 路径里。
+type Session struct {
+	sid    string
+	room   string
+	connID string
+	remote string
+
+	send chan wsFrame // 待推给客户端的出站帧（Hub 写入）
+
+	mu         sync.Mutex
+	lastActive time.Time
+	closed     bool
+}
+
+func (s *Session) roomName() string       { return s.room }
+func (s *Session) sendChan() chan wsFrame { return s.send }
+
+// evicted 在 Hub 把这个慢会话直接踢出房间后调用：把它从 sessionStore
+// 里摘掉，否则它的 sid 会一直留在 sessions 表里（轮询方还在 touch()
+// 续命），永远等不到 janitor 的不活动过期。
+func (s *Session) evicted() {
+	sessions.mu.Lock()
+	if sessions.sessions[s.sid] == s {
+		delete(sessions.sessions, s.sid)
+	}
+	sessions.mu.Unlock()
+
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
+
+func (s *Session) touch() {
+	s.mu.Lock()
+	s.lastActive = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *Session) idle() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.lastActive)
+}
+
+// sessionTimeout 复用与 WebSocket 连接相同的读超时作为不活动过期时间，
+// 以保持两种传输的超时策略一致。
+func sessionTimeout() time.Duration { return *readTimeout }
+
+// sessionStore 按 sid 管理所有回退传输的会话，并定期清理不活动的会话。
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+var sessions = &sessionStore{sessions: make(map[string]*Session)}
+
+func newSid() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// getOrCreate 返回给定 sid 的会话；若不存在则以 room/remote 创建并注册到 hub。
+func (st *sessionStore) getOrCreate(sid, room, remote string) (sess *Session, created bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if sess, ok := st.sessions[sid]; ok {
+		return sess, false
+	}
+	sess = &Session{
+		sid:        sid,
+		room:       room,
+		connID:     nextConnID(),
+		remote:     remote,
+		send:       make(chan wsFrame, 256),
+		lastActive: time.Now(),
+	}
+	st.sessions[sid] = sess
+	hub.register <- sess
+	return sess, true
+}
+
+func (st *sessionStore) get(sid string) (*Session, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	sess, ok := st.sessions[sid]
+	return sess, ok
+}
+
+func (st *sessionStore) remove(sess *Session) {
+	st.mu.Lock()
+	if st.sessions[sess.sid] == sess {
+		delete(st.sessions, sess.sid)
+	}
+	st.mu.Unlock()
+
+	sess.mu.Lock()
+	already := sess.closed
+	sess.closed = true
+	sess.mu.Unlock()
+	if !already {
+		hub.unregister <- sess
+	}
+}
+
+// janitor 定期关闭超过 sessionTimeout() 未被轮询/订阅的会话。
+func (st *sessionStore) janitor() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		st.mu.Lock()
+		stale := make([]*Session, 0)
+		for _, sess := range st.sessions {
+			if sess.idle() > sessionTimeout() {
+				stale = append(stale, sess)
+			}
+		}
+		st.mu.Unlock()
+		for _, sess := range stale {
+			log.Printf("fallback session %s (conn=%s) expired after %s idle", sess.sid, sess.connID, sessionTimeout())
+			st.remove(sess)
+		}
+	}
+}
+
+// wireFrame 是回退传输（xhr/eventsource）推给客户端的一帧的 JSON 包装。
+// 合法 UTF-8 的文本帧原样放进 Data；二进制帧或非 UTF-8 文本帧改为
+// base64 并置位 Binary，避免 JSON/SSE 编码悄悄把非法字节替换成 U+FFFD。
+type wireFrame struct {
+	Binary bool   `json:"binary,omitempty"`
+	Data   string `json:"data"`
+}
+
+func newWireFrame(frame wsFrame) wireFrame {
+	if frame.mt == websocket.BinaryMessage || !utf8.Valid(frame.data) {
+		return wireFrame{Binary: true, Data: base64.StdEncoding.EncodeToString(frame.data)}
+	}
+	return wireFrame{Data: string(frame.data)}
+}
+
+// writeSSEFrame 把一帧按 SSE 格式写出：多行文本按 SSE 规范逐行加
+// "data: " 前缀（否则首个换行之后的内容会被当成裸行丢弃），二进制帧
+// base64 编码后单行发送，并用 "event: binary" 提示客户端解码。
+func writeSSEFrame(w io.Writer, frame wsFrame) {
+	if frame.mt == websocket.BinaryMessage || !utf8.Valid(frame.data) {
+		fmt.Fprintf(w, "event: binary\ndata: %s\n\n", base64.StdEncoding.EncodeToString(frame.data))
+		return
+	}
+	for _, line := range strings.Split(string(frame.data), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// splitFallbackPath 解析 /ws/{sid}/{transport} 并返回两段。
+func splitFallbackPath(urlPath string) (sid, transport string, ok bool) {
+	trimmed := strings.TrimPrefix(urlPath, "/ws/")
+	parts := strings.SplitN(trimmed, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func logFallbackHandshake(r *http.Request, sid, transport string) {
+	log.Printf(
+		"\n==== Fallback transport request @ %s ====\n"+
+			"Transport: %s  sid=%s\n"+
+			"RequestURI: %s\nRemoteAddr: %s\nOrigin: %s\nUser-Agent: %s\n",
+		time.Now().Format(time.RFC3339), transport, sid,
+		r.RequestURI, r.RemoteAddr, r.Header.Get("Origin"), r.Header.Get("User-Agent"),
+	)
+}
+
+// handleNewSession 生成一个新的 sid，供客户端在后续回退传输请求的路径中使用。
+func handleNewSession(w http.ResponseWriter, r *http.Request) {
+	sid := newSid()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]string{"sid": sid})
+}
+
+// handleFallback 把 /ws/{sid}/xhr, /ws/{sid}/xhr_send, /ws/{sid}/eventsource
+// 分发到对应的传输实现；三者共享同一个 Session/Hub 抽象。
+func handleFallback(w http.ResponseWriter, r *http.Request) {
+	sid, transport, ok := splitFallbackPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	logFallbackHandshake(r, sid, transport)
+
+	switch transport {
+	case "xhr":
+		handleXHRPoll(w, r, sid)
+	case "xhr_send":
+		handleXHRSend(w, r, sid)
+	case "eventsource":
+		handleEventSource(w, r, sid)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func sessionRoom(r *http.Request) string {
+	if room := r.URL.Query().Get("room"); room != "" {
+		return room
+	}
+	return "lobby"
+}
+
+// handleXHRPoll 实现 SockJS 风格的长轮询接收端：阻塞直到有帧可发或超时，
+// 返回一个 JSON 字符串数组。
+func handleXHRPoll(w http.ResponseWriter, r *http.Request, sid string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sess, created := sessions.getOrCreate(sid, sessionRoom(r), r.RemoteAddr)
+	sess.touch()
+	if created {
+		log.Printf("fallback session %s (conn=%s) opened in room %q via xhr", sid, sess.connID, sess.room)
+		recorder.record(newTraceEvent(sess.connID, r.RemoteAddr, "handshake", "Handshake", []byte(sess.room)))
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	select {
+	case frame, ok := <-sess.send:
+		if !ok {
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		}
+		recorder.record(newTraceEvent(sess.connID, r.RemoteAddr, "send", messageTypeName(frame.mt), frame.data))
+		_ = json.NewEncoder(w).Encode([]wireFrame{newWireFrame(frame)})
+	case <-time.After(sessionTimeout()):
+		_ = json.NewEncoder(w).Encode([]string{})
+	case <-r.Context().Done():
+	}
+}
+
+// handleXHRSend 接收客户端->服务端的帧（JSON 字符串数组），并像普通
+// WebSocket 连接一样交给 hub 广播。
+func handleXHRSend(w http.ResponseWriter, r *http.Request, sid string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sess, ok := sessions.get(sid)
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	sess.touch()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var frames []string
+	if err := json.Unmarshal(body, &frames); err != nil {
+		http.Error(w, fmt.Sprintf("invalid frame payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, frame := range frames {
+		log.Printf("recv message: room=%s from=%s(sid=%s) type=Text len=%d", sess.room, r.RemoteAddr, sid, len(frame))
+		recorder.record(newTraceEvent(sess.connID, r.RemoteAddr, "recv", "Text", []byte(frame)))
+		hub.broadcast <- roomMessage{room: sess.room, mt: websocket.TextMessage, data: []byte(frame), sender: sess}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEventSource 实现 GET /ws/{sid}/eventsource：一条持续的 SSE 流，
+// 把该会话此后收到的每一帧推送给客户端。
+func handleEventSource(w http.ResponseWriter, r *http.Request, sid string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sess, created := sessions.getOrCreate(sid, sessionRoom(r), r.RemoteAddr)
+	sess.touch()
+	if created {
+		log.Printf("fallback session %s (conn=%s) opened in room %q via eventsource", sid, sess.connID, sess.room)
+		recorder.record(newTraceEvent(sess.connID, r.RemoteAddr, "handshake", "Handshake", []byte(sess.room)))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "data: o\n\n")
+	flusher.Flush()
+
+	ctx := r.Context()
+	heartbeat := time.NewTicker(sessionTimeout() / 3)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case frame, ok := <-sess.send:
+			if !ok {
+				fmt.Fprint(w, "data: c\n\n")
+				flusher.Flush()
+				return
+			}
+			sess.touch()
+			recorder.record(newTraceEvent(sess.connID, r.RemoteAddr, "send", messageTypeName(frame.mt), frame.data))
+			writeSSEFrame(w, frame)
+			flusher.Flush()
+		case <-heartbeat.C:
+			sess.touch()
+			fmt.Fprint(w, "data: h\n\n")
+			flusher.Flush()
+		}
+	}
+}
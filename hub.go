@@ -0,0 +1,120 @@
+package main
+
+import "sync"
+
+// member 是可以加入 Hub 某个房间的连接：原生 WebSocket 的 *Client，
+// 或 SockJS 式回退传输的 *Session 都实现这个接口。
+type member interface {
+	roomName() string
+	sendChan() chan wsFrame
+
+	// evicted 在 Hub 把这个 member 当作慢消费者直接踢出房间（而不是走
+	// 正常的 unregister 流程）时被调用，让有自己存储（比如
+	// sessionStore）的传输有机会清掉那份记录，避免变成孤儿。
+	evicted()
+}
+
+// wsFrame 是待推给某个 member 的一帧出站数据，携带原始帧类型（
+// websocket.TextMessage / websocket.BinaryMessage），以便广播路径
+// 不会把二进制帧错误地当作文本重写出去。
+type wsFrame struct {
+	mt   int
+	data []byte
+}
+
+// roomMessage 是客户端读循环投递给 Hub 的一条待广播消息。
+type roomMessage struct {
+	room   string
+	mt     int
+	data   []byte
+	sender member
+}
+
+// Hub 按房间（room）分组维护所有已连接的客户端，并在房间内广播消息。
+// 房间名取自 wsHandler 收到的 `room` 查询参数，默认 "lobby"。
+type Hub struct {
+	rooms      map[string]map[member]bool
+	broadcast  chan roomMessage
+	register   chan member
+	unregister chan member
+
+	statsMu sync.RWMutex
+	stats   map[string]int // room -> 当前连接数，供 /stats 只读访问
+}
+
+func newHub() *Hub {
+	return &Hub{
+		rooms:      make(map[string]map[member]bool),
+		broadcast:  make(chan roomMessage),
+		register:   make(chan member),
+		unregister: make(chan member),
+		stats:      make(map[string]int),
+	}
+}
+
+// run 独占地拥有 rooms 这份状态，必须作为单个 goroutine 启动。
+func (h *Hub) run() {
+	for {
+		select {
+		case c := <-h.register:
+			clients, ok := h.rooms[c.roomName()]
+			if !ok {
+				clients = make(map[member]bool)
+				h.rooms[c.roomName()] = clients
+			}
+			clients[c] = true
+			h.setStat(c.roomName(), len(clients))
+
+		case c := <-h.unregister:
+			if clients, ok := h.rooms[c.roomName()]; ok {
+				if _, ok := clients[c]; ok {
+					delete(clients, c)
+					close(c.sendChan())
+					if len(clients) == 0 {
+						delete(h.rooms, c.roomName())
+						h.setStat(c.roomName(), 0)
+					} else {
+						h.setStat(c.roomName(), len(clients))
+					}
+				}
+			}
+
+		case m := <-h.broadcast:
+			for c := range h.rooms[m.room] {
+				if c == m.sender {
+					continue // 不把消息回发给发送者本身，这是房间中继而非逐连接回显
+				}
+				select {
+				case c.sendChan() <- wsFrame{mt: m.mt, data: m.data}:
+				default:
+					// 客户端发送缓冲已满，视为慢客户端并断开
+					close(c.sendChan())
+					delete(h.rooms[m.room], c)
+					h.setStat(m.room, len(h.rooms[m.room]))
+					c.evicted()
+				}
+			}
+		}
+	}
+}
+
+func (h *Hub) setStat(room string, count int) {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+	if count == 0 {
+		delete(h.stats, room)
+		return
+	}
+	h.stats[room] = count
+}
+
+// Stats 返回每个房间当前的连接数快照，供 /stats 端点使用。
+func (h *Hub) Stats() map[string]int {
+	h.statsMu.RLock()
+	defer h.statsMu.RUnlock()
+	out := make(map[string]int, len(h.stats))
+	for room, n := range h.stats {
+		out[room] = n
+	}
+	return out
+}
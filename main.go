@@ -11,15 +11,56 @@ import (
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/acme/autocert"
+
 	"github.com/gorilla/websocket"
 )
 
 var (
 	defaultPort = getEnv("PORT", "8080")
 	addr        = flag.String("addr", ":"+defaultPort, "http service address")
+
+	tlsCert          = flag.String("tls-cert", "", "path to a PEM certificate file; serves wss:// when set together with -tls-key")
+	tlsKey           = flag.String("tls-key", "", "path to a PEM private key file; serves wss:// when set together with -tls-cert")
+	autocertEnabled  = flag.Bool("autocert", false, "serve wss:// using automatically provisioned Let's Encrypt certificates (requires -domains)")
+	autocertDomains  = flag.String("domains", "", "comma-separated domains to request autocert certificates for")
+	autocertCacheDir = flag.String("autocert-cache", "autocert-cache", "directory used by autocert to cache certificates")
+
+	compressEnabled   = flag.Bool("compress", false, "negotiate the permessage-deflate extension with clients")
+	compressLevel     = flag.Int("compress-level", 1, "deflate compression level (1-9, see flate.BestSpeed..BestCompression) used when -compress is enabled")
+	compressThreshold = flag.Int("compress-threshold", 0, "only compress outgoing messages at least this many bytes (0 = always compress when negotiated)")
+
+	readLimit    = flag.Int64("read-limit", 1<<20, "maximum message size in bytes accepted from a client")
+	readTimeout  = flag.Duration("read-timeout", 60*time.Second, "read deadline, renewed on every pong")
+	writeTimeout = flag.Duration("write-timeout", 5*time.Second, "deadline for writing control frames (ping/close)")
+	pingInterval = flag.Duration("ping-interval", 30*time.Second, "interval between keepalive pings")
+
+	dialURL         = flag.String("dial", "", "dial an outbound WebSocket endpoint as a client instead of running a server")
+	dialSubprotocol = flag.String("subprotocol", "", "comma-separated Sec-WebSocket-Protocol list to request with -dial")
+	dialOrigin      = flag.String("origin", "", "Origin header to send with -dial")
+	dialInsecure    = flag.Bool("insecure", false, "skip TLS certificate verification with -dial")
+	dialProxy       = flag.String("proxy", "", "HTTP(S) proxy URL to use with -dial")
+	dialBinary      = flag.Bool("binary", false, "relay stdin as length-prefixed binary frames instead of newline-delimited text frames with -dial")
+	dialHeaders     headerFlags
+
+	recordFile  = flag.String("record", "", "append every handshake and frame to this JSONL file")
+	replayFile  = flag.String("replay", "", "replay client frames recorded in this JSONL file against the -dial target")
+	replaySpeed = flag.Float64("replay-speed", 1, "replay speed multiplier relative to the original inter-frame delays")
 )
 
+// hub 持有所有房间的客户端集合，由单个 goroutine (hub.run) 驱动。
+var hub = newHub()
+
+// recorder 把握手/帧写入 -record 指定的文件，并支撑 /trace/{id} 实时订阅。
+// 在 main() 中按 -record 的值初始化。
+var recorder *Recorder
+
+func init() {
+	flag.Var(&dialHeaders, "header", "extra request header as key=value to send with -dial (repeatable)")
+}
+
 // WebSocket 升级器（开发环境允许任意 Origin；生产请按需校验）
+// EnableCompression 在 main() 中根据 -compress 标志设置。
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  4096,
 	WriteBufferSize: 4096,
@@ -32,12 +73,76 @@ var upgrader = websocket.Upgrader{
 func main() {
 	flag.Parse()
 
+	if *dialURL != "" {
+		var err error
+		if *replayFile != "" {
+			err = runReplay()
+		} else {
+			err = runDialClient()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *pingInterval <= 0 {
+		log.Fatal("-ping-interval must be > 0")
+	}
+	if *readTimeout <= 0 {
+		log.Fatal("-read-timeout must be > 0")
+	}
+
+	var err error
+	recorder, err = newRecorder(*recordFile)
+	if err != nil {
+		log.Fatalf("open -record file: %v", err)
+	}
+
+	upgrader.EnableCompression = *compressEnabled
+
+	go hub.run()
+	go sessions.janitor()
+
 	http.HandleFunc("/ws", wsHandler)
+	http.HandleFunc("/ws/new", handleNewSession)
+	http.HandleFunc("/ws/", handleFallback)
+	http.HandleFunc("/stats", handleStats)
+	http.HandleFunc("/trace/", handleTrace)
 	http.HandleFunc("/", homeHandler)
 
-	log.Printf("WebSocket echo server listening on %s  (ws://127.0.0.1%s/ws)\n", *addr, *addr)
-	if err := http.ListenAndServe(*addr, nil); err != nil {
-		log.Fatal("ListenAndServe:", err)
+	switch {
+	case *autocertEnabled:
+		if *autocertDomains == "" {
+			log.Fatal("-autocert requires -domains to be set")
+		}
+		domains := strings.Split(*autocertDomains, ",")
+		for i := range domains {
+			domains[i] = strings.TrimSpace(domains[i])
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(*autocertCacheDir),
+		}
+		server := &http.Server{
+			Addr:      *addr,
+			TLSConfig: m.TLSConfig(),
+		}
+		log.Printf("WebSocket echo server listening on %s with autocert for %v  (wss://%s/ws)\n", *addr, domains, domains[0])
+		if err := server.ListenAndServeTLS("", ""); err != nil {
+			log.Fatal("ListenAndServeTLS:", err)
+		}
+	case *tlsCert != "" && *tlsKey != "":
+		log.Printf("WebSocket echo server listening on %s  (wss://127.0.0.1%s/ws)\n", *addr, *addr)
+		if err := http.ListenAndServeTLS(*addr, *tlsCert, *tlsKey, nil); err != nil {
+			log.Fatal("ListenAndServeTLS:", err)
+		}
+	default:
+		log.Printf("WebSocket echo server listening on %s  (ws://127.0.0.1%s/ws)\n", *addr, *addr)
+		if err := http.ListenAndServe(*addr, nil); err != nil {
+			log.Fatal("ListenAndServe:", err)
+		}
 	}
 }
 
@@ -76,76 +181,48 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		origin, ua, subprotoRequested, query, string(headerJSON), string(cookieJSON),
 	)
 
+	// room 查询参数决定客户端加入哪个广播房间，默认 "lobby"
+	room := query.Get("room")
+	if room == "" {
+		room = "lobby"
+	}
+
 	// —— 升级为 WebSocket —— //
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("upgrade error: %v", err)
 		return
 	}
-	defer conn.Close()
 
 	// 记录最终选中的子协议
 	if sp := conn.Subprotocol(); sp != "" {
 		log.Printf("Subprotocol (selected): %s", sp)
 	}
 
-	// 心跳与超时（可根据需要调整）
-	conn.SetReadLimit(1 << 20) // 1MB
-	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	conn.SetPongHandler(func(string) error {
-		// 收到 pong 后延长读超时
-		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		return nil
-	})
-
-	// 定时发送 ping 保活
-	pingTicker := time.NewTicker(30 * time.Second)
-	defer pingTicker.Stop()
-
-	// 写协程（只负责发送 ping）
-	writeErrCh := make(chan error, 1)
-	go func() {
-		for range pingTicker.C {
-			if err := conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(5*time.Second)); err != nil {
-				writeErrCh <- fmt.Errorf("write ping error: %w", err)
-				return
-			}
-		}
-	}()
-
-	// 读循环 + 回显
-	for {
-		select {
-		case err := <-writeErrCh:
-			log.Printf("writer goroutine exit: %v", err)
-			return
-		default:
-			mt, msg, err := conn.ReadMessage()
-			if err != nil {
-				// 常见：客户端正常关闭会出现 CloseError
-				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-					log.Printf("client closed: %v", err)
-				} else {
-					log.Printf("read error: %v", err)
-				}
-				return
-			}
-
-			// 打印消息（文本过长时可截断）
-			preview := string(msg)
-			if len(preview) > 512 {
-				preview = preview[:512] + "...(truncated)"
-			}
-			log.Printf("recv message: type=%s len=%d preview=%q",
-				messageTypeName(mt), len(msg), preview)
-
-			// 原样回显
-			if err := conn.WriteMessage(mt, msg); err != nil {
-				log.Printf("write error: %v", err)
-				return
-			}
+	// permessage-deflate 是否协商成功（双方都声明了该扩展）
+	compressed := *compressEnabled && strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	if compressed {
+		if err := conn.SetCompressionLevel(*compressLevel); err != nil {
+			log.Printf("invalid -compress-level %d: %v", *compressLevel, err)
 		}
 	}
+	log.Printf("compression negotiated: %v (requested extensions: %q)", compressed, r.Header.Get("Sec-WebSocket-Extensions"))
+
+	connID := nextConnID()
+	client := &Client{hub: hub, conn: conn, send: make(chan wsFrame, 256), room: room, id: r.RemoteAddr, compressed: compressed, connID: connID}
+	hub.register <- client
+
+	log.Printf("client %s (conn=%s) joined room %q", client.id, connID, room)
+	recorder.record(newTraceEvent(connID, r.RemoteAddr, "handshake", "Handshake", []byte(room)))
+
+	go client.writePump()
+	client.readPump() // 阻塞直至连接关闭，负责向 hub 注销
+}
+
+// handleStats 返回每个房间当前的连接数，便于观察广播拓扑。
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(hub.Stats())
 }
 
 // 提供一个简单的测试页面： http://localhost:8080/
@@ -154,12 +231,16 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	if strings.HasPrefix(host, "[::]") || strings.HasPrefix(host, "0.0.0.0") {
 		host = "127.0.0.1" + strings.TrimPrefix(host, "0.0.0.0")
 	}
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
 	html := `<!doctype html>
 <html>
 <head><meta charset="utf-8"><title>WS Echo Test</title></head>
 <body>
 <h3>WebSocket Echo Test</h3>
-<p>连接示例：<code>ws://` + host + `/ws?uid=123&token=abc</code></p>
+<p>连接示例：<code>` + scheme + `://` + host + `/ws?uid=123&token=abc</code></p>
 <input id="qs" style="width: 420px" value="uid=123&token=abc">
 <button onclick="connect()">Connect</button>
 <div id="status"></div>
@@ -171,7 +252,8 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 let ws;
 function connect() {
   const qs = document.getElementById('qs').value;
-  const url = 'ws://' + location.host + '/ws' + (qs ? '?' + qs : '');
+  const scheme = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const url = scheme + '//' + location.host + '/ws' + (qs ? '?' + qs : '');
   ws = new WebSocket(url, ['json']); // 测试子协议
   ws.onopen = () => log('open');
   ws.onmessage = ev => log('recv: ' + ev.data);
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// TraceEvent 是 JSONL 记录文件中的一行：一次握手或一帧消息。
+type TraceEvent struct {
+	Time       string `json:"time"`
+	Direction  string `json:"direction"` // "handshake", "recv" (客户端->服务端) 或 "send" (服务端->客户端)
+	ConnID     string `json:"conn_id"`
+	RemoteAddr string `json:"remote_addr"`
+	Type       string `json:"type"` // 消息类型名，握手事件为 "Handshake"
+	Length     int    `json:"length"`
+	Payload    string `json:"payload,omitempty"`     // 合法 UTF-8 时使用
+	PayloadB64 string `json:"payload_b64,omitempty"` // 二进制或非 UTF-8 时使用
+}
+
+func newTraceEvent(connID, remoteAddr, direction, typeName string, payload []byte) TraceEvent {
+	ev := TraceEvent{
+		Time:       nowRFC3339(),
+		Direction:  direction,
+		ConnID:     connID,
+		RemoteAddr: remoteAddr,
+		Type:       typeName,
+		Length:     len(payload),
+	}
+	if utf8.Valid(payload) {
+		ev.Payload = string(payload)
+	} else {
+		ev.PayloadB64 = base64.StdEncoding.EncodeToString(payload)
+	}
+	return ev
+}
+
+// Recorder 把每个连接的握手与帧写入 -record 指定的 JSONL 文件（若启用），
+// 并让 GET /trace/{id} 能够实时订阅某个连接当前产生的事件。
+type Recorder struct {
+	mu sync.Mutex
+	w  io.Writer
+
+	subMu sync.Mutex
+	subs  map[string][]chan []byte
+}
+
+func newRecorder(path string) (*Recorder, error) {
+	rec := &Recorder{subs: make(map[string][]chan []byte)}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		rec.w = f
+	}
+	return rec, nil
+}
+
+func (r *Recorder) record(ev TraceEvent) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	if r.w != nil {
+		r.mu.Lock()
+		_, _ = r.w.Write(line)
+		r.mu.Unlock()
+	}
+
+	r.subMu.Lock()
+	for _, ch := range r.subs[ev.ConnID] {
+		select {
+		case ch <- line:
+		default: // 订阅者跟不上，丢弃而不是阻塞记录路径
+		}
+	}
+	r.subMu.Unlock()
+}
+
+func (r *Recorder) subscribe(connID string) chan []byte {
+	ch := make(chan []byte, 64)
+	r.subMu.Lock()
+	r.subs[connID] = append(r.subs[connID], ch)
+	r.subMu.Unlock()
+	return ch
+}
+
+func (r *Recorder) unsubscribe(connID string, ch chan []byte) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	chans := r.subs[connID]
+	for i, c := range chans {
+		if c == ch {
+			r.subs[connID] = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+	if len(r.subs[connID]) == 0 {
+		delete(r.subs, connID)
+	}
+}
+
+var connCounter int64
+
+// nextConnID 为每个新连接生成一个用于 /trace/{id} 与记录文件的唯一标识。
+func nextConnID() string {
+	return strconv.FormatInt(atomic.AddInt64(&connCounter, 1), 10)
+}
+
+func nowRFC3339() string {
+	return time.Now().Format(time.RFC3339Nano)
+}
+
+// handleTrace 实现 GET /trace/{id}：把该连接接下来产生的事件以 JSONL 的形式
+// 持续推送给调用方，典型用法是 `curl http://host/trace/1 | jq`。
+func handleTrace(w http.ResponseWriter, r *http.Request) {
+	connID := strings.TrimPrefix(r.URL.Path, "/trace/")
+	if connID == "" {
+		http.Error(w, "missing connection id", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := recorder.subscribe(connID)
+	defer recorder.unsubscribe(connID, ch)
+
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-ch:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// runReplay 读取一份由 -record 产生的 JSONL 文件，把其中记录的客户端->服务端
+// 帧按原始帧间延迟（乘以 -replay-speed）重新发送到 -dial 指定的目标地址。
+func runReplay() error {
+	if *dialURL == "" {
+		return fmt.Errorf("-replay requires -dial to specify the target URL")
+	}
+	if *replaySpeed <= 0 {
+		return fmt.Errorf("-replay-speed must be > 0")
+	}
+
+	f, err := os.Open(*replayFile)
+	if err != nil {
+		return fmt.Errorf("open replay file: %w", err)
+	}
+	defer f.Close()
+
+	var events []TraceEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var ev TraceEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return fmt.Errorf("parse trace line: %w", err)
+		}
+		if ev.Direction != "recv" {
+			continue // 只重放客户端->服务端的帧
+		}
+		events = append(events, ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read replay file: %w", err)
+	}
+
+	dialer, header, err := buildDialerAndHeader()
+	if err != nil {
+		return err
+	}
+
+	conn, _, err := dialer.Dial(*dialURL, header)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", *dialURL, err)
+	}
+	defer conn.Close()
+
+	log.Printf("replaying %d frame(s) from %s to %s at %gx speed", len(events), *replayFile, *dialURL, *replaySpeed)
+
+	var prevTime time.Time
+	for i, ev := range events {
+		t, parseErr := time.Parse(time.RFC3339Nano, ev.Time)
+		if i > 0 && parseErr == nil && !prevTime.IsZero() {
+			delay := time.Duration(float64(t.Sub(prevTime)) / *replaySpeed)
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+		}
+		if parseErr == nil {
+			prevTime = t
+		}
+
+		payload, mt, err := ev.decodePayload()
+		if err != nil {
+			return fmt.Errorf("decode frame %d: %w", i, err)
+		}
+		if err := conn.WriteMessage(mt, payload); err != nil {
+			return fmt.Errorf("write frame %d: %w", i, err)
+		}
+		log.Printf("sent frame %d/%d: type=%s len=%d", i+1, len(events), messageTypeName(mt), len(payload))
+	}
+
+	return nil
+}
+
+// decodePayload 把一条 TraceEvent 还原为可发送的 (payload, messageType)。
+func (ev TraceEvent) decodePayload() ([]byte, int, error) {
+	mt := websocket.TextMessage
+	if ev.Type == messageTypeName(websocket.BinaryMessage) {
+		mt = websocket.BinaryMessage
+	}
+	if ev.PayloadB64 != "" {
+		data, err := base64.StdEncoding.DecodeString(ev.PayloadB64)
+		return data, mt, err
+	}
+	return []byte(ev.Payload), mt, nil
+}